@@ -0,0 +1,90 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// fingerprintLength is the number of hex characters of the fingerprint hash
+// that we keep -- enough to make accidental collisions between unrelated
+// failure modes very unlikely, short enough to read in a title.
+const fingerprintLength = 8
+
+var (
+	frameFileLineRE  = regexp.MustCompile(`\.go:\d+`)
+	fatalPrefixRE    = regexp.MustCompile(`^F\d{6} \d{2}:\d{2}:\d{2}(?:\.\d+)? \d+ `)
+	conditionFailRE  = regexp.MustCompile(`condition failed to evaluate.*`)
+	goroutineIDRE    = regexp.MustCompile(`goroutine \d+`)
+	addressRE        = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+	timestampRE      = regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(?:\.\d+)?\b`)
+	durationRE       = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)\b`)
+	nodeIDRE         = regexp.MustCompile(`\[n\d+(?:,s\d+)?(?:,r\d+/\d+)?(?::[^\]]*)?\]`)
+)
+
+// normalizeFingerprintLine strips the parts of a line that vary between
+// otherwise-identical runs of the same failure -- goroutine IDs, memory
+// addresses, timestamps, durations, and node/range IDs like "n3,s3,r1/3" --
+// so that the same failure mode normalizes the same way regardless of when
+// or where it ran.
+func normalizeFingerprintLine(line string) string {
+	line = fatalPrefixRE.ReplaceAllString(line, "F")
+	line = goroutineIDRE.ReplaceAllString(line, "goroutine")
+	line = addressRE.ReplaceAllString(line, "0x")
+	line = nodeIDRE.ReplaceAllString(line, "[node]")
+	line = timestampRE.ReplaceAllString(line, "")
+	line = durationRE.ReplaceAllString(line, "")
+	return strings.TrimSpace(line)
+}
+
+// fingerprintLines extracts the parts of a captured failure that identify
+// *how* it failed, as distinct from *when* or *where in the cluster*: the
+// top few stack frames, the F-prefixed fatal line, and any
+// "condition failed to evaluate" message.
+func fingerprintLines(data string) []string {
+	const maxFrames = 5
+
+	var lines []string
+	frames := 0
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case fatalPrefixRE.MatchString(text):
+			lines = append(lines, normalizeFingerprintLine(text))
+		case strings.Contains(text, "condition failed to evaluate"):
+			lines = append(lines, normalizeFingerprintLine(conditionFailRE.FindString(text)))
+		case frames < maxFrames && frameFileLineRE.MatchString(text):
+			lines = append(lines, normalizeFingerprintLine(text))
+			frames++
+		}
+	}
+	return lines
+}
+
+// fingerprintFailure computes a short, stable fingerprint for a captured
+// test failure by normalizing and hashing the lines that describe how it
+// failed. Two runs of the same failure mode hash to the same fingerprint
+// regardless of which node or process they ran on or when; two distinct
+// failure modes for the same test hash to different fingerprints, so they
+// file as separate issues instead of piling into one umbrella issue.
+func fingerprintFailure(data string) string {
+	sum := sha256.Sum256([]byte(strings.Join(fingerprintLines(data), "\n")))
+	return hex.EncodeToString(sum[:])[:fingerprintLength]
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"code.gitea.io/sdk/gitea"
+)
+
+const (
+	giteaServerURLEnv = "GITEA_SERVER_URL"
+	giteaAPITokenEnv  = "GITEA_API_TOKEN"
+
+	// defaultGiteaLabelColor is used for any label resolveLabelIDs has to
+	// create because it doesn't already exist on the repo. The color is
+	// arbitrary -- Gitea/Forgejo requires one, but nothing in github-post
+	// depends on its value.
+	defaultGiteaLabelColor = "#ededed"
+)
+
+// giteaIssue is the minimal view of a Gitea/Forgejo issue giteaTracker
+// needs.
+type giteaIssue struct {
+	Index int64
+}
+
+// giteaAPI is the subset of the Gitea/Forgejo REST surface giteaTracker
+// depends on, expressed independently of the SDK's own types so that tests
+// can fake it without standing up a real server.
+type giteaAPI interface {
+	SearchIssues(owner, repo, title string) ([]giteaIssue, error)
+	CreateIssue(owner, repo, title, body string, labels []string) (giteaIssue, error)
+	CreateComment(owner, repo string, index int64, body string) error
+}
+
+// giteaTracker implements IssueTracker against a Gitea or Forgejo instance.
+type giteaTracker struct {
+	api giteaAPI
+}
+
+func newGiteaTracker(api giteaAPI) *giteaTracker {
+	return &giteaTracker{api: api}
+}
+
+func newGiteaTrackerFromEnv() (*giteaTracker, error) {
+	url, ok := os.LookupEnv(giteaServerURLEnv)
+	if !ok {
+		return nil, fmt.Errorf("Gitea server URL environment variable %s is not set", giteaServerURLEnv)
+	}
+	token, ok := os.LookupEnv(giteaAPITokenEnv)
+	if !ok {
+		return nil, fmt.Errorf("Gitea API token environment variable %s is not set", giteaAPITokenEnv)
+	}
+	client, err := gitea.NewClient(url, gitea.SetToken(token))
+	if err != nil {
+		return nil, err
+	}
+	return newGiteaTracker(giteaClient{client}), nil
+}
+
+func (t *giteaTracker) Search(_ context.Context, owner, repo, title string) (bool, Issue, error) {
+	issues, err := t.api.SearchIssues(owner, repo, title)
+	if err != nil {
+		return false, Issue{}, err
+	}
+	if len(issues) == 0 {
+		return false, Issue{}, nil
+	}
+	return true, Issue{Number: int(issues[0].Index)}, nil
+}
+
+func (t *giteaTracker) Create(_ context.Context, owner, repo string, req IssueRequest) error {
+	_, err := t.api.CreateIssue(owner, repo, req.Title, req.Body, req.Labels)
+	return err
+}
+
+func (t *giteaTracker) Comment(_ context.Context, owner, repo string, number int, body string) error {
+	return t.api.CreateComment(owner, repo, int64(number), body)
+}
+
+// Label is a no-op for Gitea/Forgejo: CreateIssue above already resolves and
+// attaches issueLabels at creation time, and there's nothing useful to
+// relabel on the comment path.
+func (t *giteaTracker) Label(context.Context, string, string, int, []string) error {
+	return nil
+}
+
+// giteaClient adapts a real *gitea.Client to giteaAPI.
+type giteaClient struct {
+	client *gitea.Client
+}
+
+func (c giteaClient) SearchIssues(owner, repo, title string) ([]giteaIssue, error) {
+	issues, _, err := c.client.ListRepoIssues(owner, repo, gitea.ListIssueOption{
+		State:   gitea.StateOpen,
+		KeyWord: title,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]giteaIssue, len(issues))
+	for i, issue := range issues {
+		out[i] = giteaIssue{Index: issue.Index}
+	}
+	return out, nil
+}
+
+func (c giteaClient) CreateIssue(owner, repo, title, body string, labels []string) (giteaIssue, error) {
+	labelIDs, err := c.resolveLabelIDs(owner, repo, labels)
+	if err != nil {
+		return giteaIssue{}, err
+	}
+	issue, _, err := c.client.CreateIssue(owner, repo, gitea.CreateIssueOption{
+		Title:  title,
+		Body:   body,
+		Labels: labelIDs,
+	})
+	if err != nil {
+		return giteaIssue{}, err
+	}
+	return giteaIssue{Index: issue.Index}, nil
+}
+
+// resolveLabelIDs maps label names to the repo-specific IDs Gitea/Forgejo
+// issues are tagged with, creating any label that doesn't already exist on
+// the repo. Unlike GitHub, Gitea/Forgejo addresses labels by ID rather than
+// by name, so this lookup (or creation) has to happen before every Create.
+func (c giteaClient) resolveLabelIDs(owner, repo string, names []string) ([]int64, error) {
+	existing, _, err := c.client.ListRepoLabels(owner, repo, gitea.ListLabelsOptions{})
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]int64, len(existing))
+	for _, label := range existing {
+		byName[label.Name] = label.ID
+	}
+
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		if id, ok := byName[name]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		label, _, err := c.client.CreateLabel(owner, repo, gitea.CreateLabelOption{
+			Name:  name,
+			Color: defaultGiteaLabelColor,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, label.ID)
+	}
+	return ids, nil
+}
+
+func (c giteaClient) CreateComment(owner, repo string, index int64, body string) error {
+	_, _, err := c.client.CreateIssueComment(owner, repo, index, gitea.CreateIssueCommentOption{
+		Body: body,
+	})
+	return err
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// githubTracker implements IssueTracker against github.com (or a GitHub
+// Enterprise instance) via go-github.
+type githubTracker struct {
+	client *github.Client
+}
+
+func newGithubTrackerFromEnv() (*githubTracker, error) {
+	token, ok := os.LookupEnv(githubAPITokenEnv)
+	if !ok {
+		return nil, fmt.Errorf("GitHub API token environment variable %s is not set", githubAPITokenEnv)
+	}
+	ctx := context.Background()
+	client := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)))
+	return &githubTracker{client: client}, nil
+}
+
+func (t *githubTracker) Search(
+	ctx context.Context, owner, repo, title string,
+) (bool, Issue, error) {
+	result, _, err := t.client.Search.Issues(ctx, fmt.Sprintf(
+		`"%s" in:title repo:%s/%s is:open`, title, owner, repo,
+	), &github.SearchOptions{
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return false, Issue{}, err
+	}
+	if result.Total == nil || *result.Total == 0 {
+		return false, Issue{}, nil
+	}
+	return true, Issue{Number: *result.Issues[0].Number}, nil
+}
+
+func (t *githubTracker) Create(ctx context.Context, owner, repo string, req IssueRequest) error {
+	labels := append([]string(nil), req.Labels...)
+	_, _, err := t.client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title:  github.String(req.Title),
+		Body:   github.String(req.Body),
+		Labels: &labels,
+	})
+	return err
+}
+
+func (t *githubTracker) Comment(ctx context.Context, owner, repo string, number int, body string) error {
+	_, _, err := t.client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{
+		Body: github.String(body),
+	})
+	return err
+}
+
+func (t *githubTracker) Label(
+	ctx context.Context, owner, repo string, number int, labels []string,
+) error {
+	_, _, err := t.client.Issues.AddLabelsToIssue(ctx, owner, repo, number, labels)
+	return err
+}
@@ -0,0 +1,248 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Tamir Duberstein (tamird@gmail.com)
+
+// github-post reads a captured test failure from a TeamCity stress build off
+// stdin and either files a new GitHub issue describing it, or adds a comment
+// to the existing issue for the same test if one is already open.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	githubAPITokenEnv    = "GITHUB_API_TOKEN"
+	teamcityVCSNumberEnv = "BUILD_VCS_NUMBER"
+	teamcityServerURLEnv = "TC_SERVER_URL"
+	teamcityBuildIDEnv   = "TC_BUILD_ID"
+
+	pkgEnv     = "PKG"
+	tagsEnv    = "TAGS"
+	goFlagsEnv = "GOFLAGS"
+
+	cockroachPkgPrefix = "github.com/cockroachdb/cockroach/"
+
+	cockroachOwner = "cockroachdb"
+	cockroachRepo  = "cockroach"
+
+	// issueTrackerEnv selects which forge backend runGH files issues
+	// against. Defaults to "github" when unset.
+	issueTrackerEnv = "ISSUE_TRACKER"
+
+	// githubIssueBodyMaximumLength is the maximum number of characters
+	// GitHub allows in an issue or comment body. The API silently
+	// truncates anything longer, so we trim to this ourselves rather
+	// than lose the fact that truncation happened.
+	githubIssueBodyMaximumLength = 1 << 16
+
+	unknownTestName = "<unknown>"
+)
+
+// issueLabels are applied to every issue runGH files, regardless of
+// backend.
+var issueLabels = []string{"C-test-failure", "O-robot"}
+
+// testFailureRE picks the name of the failing test out of `go test -v`
+// (or stress, which wraps it) output.
+var testFailureRE = regexp.MustCompile(`^--- FAIL: (?P<test>\S+)`)
+
+func main() {
+	flag.Parse()
+
+	tracker, err := newIssueTracker(os.Getenv(issueTrackerEnv))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	if subURL, ok := os.LookupEnv(pubsubSubscriptionEnv); ok {
+		if err := runPubSub(ctx, subURL, tracker, httpLogFetcher, rateLimiterFromFlag()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := runGH(ctx, os.Stdin, tracker); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// formatBody assembles the portion of the issue/comment body that's shared
+// between a freshly filed issue and a comment added to a pre-existing one:
+// an optional redaction summary, the commit under test, the build
+// parameters, a link to the failing build's log, and the failure excerpt
+// itself.
+func formatBody(
+	sha, serverURL string, buildID int, parameters, excerpt string, matchedRules []string, fp string,
+) string {
+	var prefix string
+	if len(matchedRules) > 0 {
+		prefix = fmt.Sprintf("Redacted rules matched: %s\n\n", strings.Join(matchedRules, ", "))
+	}
+	return fmt.Sprintf(`%sSHA: https://github.com/%s/%s/commits/%s
+
+Parameters:
+%s
+
+Stress build found a failed test: %s/viewLog.html?buildId=%d&tab=buildLog
+
+%s
+<!-- fingerprint: %s -->
+`, prefix, cockroachOwner, cockroachRepo, sha, parameters, serverURL, buildID, excerpt, fp)
+}
+
+// failureParams holds the per-failure inputs to postFailure. In the
+// single-shot (stdin) mode these all come from the TeamCity environment
+// variables of the process running github-post; in pub/sub ingestion mode
+// they come from each message instead, since many failures from many
+// TeamCity agents are processed by one long-running process and can't share
+// a single set of environment variables.
+type failureParams struct {
+	sha         string
+	serverURL   string
+	buildID     int
+	packageName string
+	parameters  string
+}
+
+func failureParamsFromEnv() (failureParams, error) {
+	sha, ok := os.LookupEnv(teamcityVCSNumberEnv)
+	if !ok {
+		return failureParams{}, fmt.Errorf("VCS number environment variable %s is not set", teamcityVCSNumberEnv)
+	}
+	serverURL, ok := os.LookupEnv(teamcityServerURLEnv)
+	if !ok {
+		return failureParams{}, fmt.Errorf("TeamCity server URL environment variable %s is not set", teamcityServerURLEnv)
+	}
+	buildIDStr, ok := os.LookupEnv(teamcityBuildIDEnv)
+	if !ok {
+		return failureParams{}, fmt.Errorf("TeamCity build ID environment variable %s is not set", teamcityBuildIDEnv)
+	}
+	buildID, err := strconv.Atoi(buildIDStr)
+	if err != nil {
+		return failureParams{}, fmt.Errorf("parsing %s: %s", teamcityBuildIDEnv, err)
+	}
+
+	pkg, ok := os.LookupEnv(pkgEnv)
+	if !ok {
+		return failureParams{}, fmt.Errorf("package environment variable %s is not set", pkgEnv)
+	}
+
+	var tags []string
+	for _, env := range []string{tagsEnv, goFlagsEnv} {
+		if value, ok := os.LookupEnv(env); ok {
+			tags = append(tags, env+"="+value)
+		}
+	}
+
+	return failureParams{
+		sha:         sha,
+		serverURL:   serverURL,
+		buildID:     buildID,
+		packageName: strings.TrimPrefix(pkg, cockroachPkgPrefix),
+		parameters:  "```\n" + strings.Join(tags, "\n") + "\n```",
+	}, nil
+}
+
+// testNameFromFailure picks the name of the failing test out of captured
+// `go test -v` (or stress, which wraps it) output, for callers that don't
+// already know it structurally.
+func testNameFromFailure(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if match := testFailureRE.FindStringSubmatch(scanner.Text()); match != nil {
+			return match[1]
+		}
+	}
+	return unknownTestName
+}
+
+// postFailure runs the shared formatting/redaction/fingerprinting/dedup
+// pipeline for one captured test failure: it files a new issue, or if one
+// is already open for the same test and failure mode, comments on it
+// instead.
+func postFailure(
+	ctx context.Context, tracker IssueTracker, params failureParams, testName string, data []byte,
+) error {
+	fp := fingerprintFailure(string(data))
+
+	rules, err := loadRedactRules()
+	if err != nil {
+		return err
+	}
+	excerpt, matchedRules := redact(string(data), rules)
+	if len(excerpt) > githubIssueBodyMaximumLength/2 {
+		excerpt = excerpt[:githubIssueBodyMaximumLength/2] + "\n\n(... excerpt truncated ...)"
+	}
+
+	body := formatBody(params.sha, params.serverURL, params.buildID, params.parameters, excerpt, matchedRules, fp)
+	if len(body) > githubIssueBodyMaximumLength {
+		body = body[:githubIssueBodyMaximumLength]
+	}
+
+	// The fingerprint is embedded in the title (rather than relied upon
+	// only via the hidden HTML comment in the body) so that Search, which
+	// matches on title, naturally separates distinct failure modes of the
+	// same test into distinct issues while still collapsing true repeats
+	// of the same failure into comments on one issue.
+	title := fmt.Sprintf("%s: %s failed under stress [fp:%s]", params.packageName, testName, fp)
+
+	found, issue, err := tracker.Search(ctx, cockroachOwner, cockroachRepo, title)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		if err := tracker.Label(ctx, cockroachOwner, cockroachRepo, issue.Number, issueLabels); err != nil {
+			return err
+		}
+		return tracker.Comment(ctx, cockroachOwner, cockroachRepo, issue.Number, body)
+	}
+
+	return tracker.Create(ctx, cockroachOwner, cockroachRepo, IssueRequest{
+		Title:  title,
+		Body:   body,
+		Labels: issueLabels,
+	})
+}
+
+// runGH is the single-shot entry point: it reads one captured failure from
+// input, with the TeamCity build parameters coming from the environment.
+func runGH(ctx context.Context, input io.Reader, tracker IssueTracker) error {
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return err
+	}
+
+	params, err := failureParamsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	return postFailure(ctx, tracker, params, testNameFromFailure(data), data)
+}
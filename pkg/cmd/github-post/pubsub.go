@@ -0,0 +1,277 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/awssnssqs"
+	_ "gocloud.dev/pubsub/gcppubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+const (
+	// pubsubSubscriptionEnv, if set, names a gocloud.dev/pubsub
+	// subscription URL (e.g. "gcppubsub://proj/stress-failures" or
+	// "awssqs://...") and switches github-post from its single-shot
+	// stdin mode into a long-running subscriber that ingests failures
+	// reported by many parallel TeamCity stress agents.
+	pubsubSubscriptionEnv = "GITHUB_POST_PUBSUB_URL"
+
+	// shardNumFileEnv, if set, names a file containing this process's
+	// shard number, analogous to the stress harness's own .shard_num.
+	// It's surfaced only for logging/diagnostics and does not partition
+	// work: pub/sub's competing-consumer delivery already guarantees a
+	// given message is handed to exactly one subscriber, which is all
+	// readShardNum is relied on for. It does NOT coordinate across
+	// shards when two different TeamCity stress agents report the same
+	// failure to two different shard processes -- fingerprintCache is
+	// local to each process, so both can race through Search-then-Create
+	// at once. That race is accepted: the tracker's own Search is the
+	// cross-process backstop, the same way two concurrent runGH
+	// invocations already rely on it today.
+	shardNumFileEnv = "GITHUB_POST_SHARD_NUM_FILE"
+
+	// defaultDedupeCacheSize bounds the number of recently-seen
+	// (package, test, fingerprint) keys fingerprintCache remembers.
+	defaultDedupeCacheSize = 4096
+)
+
+var rateLimitPerMinuteFlag = flag.Int(
+	"rate-limit-per-minute", 0,
+	"cap on issue tracker API calls per minute in pub/sub ingestion mode (0 means unlimited)",
+)
+
+func rateLimiterFromFlag() *rate.Limiter {
+	if *rateLimitPerMinuteFlag <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(float64(*rateLimitPerMinuteFlag)/60), 1)
+}
+
+// failureMessage is the JSON payload of a pub/sub message published by a
+// TeamCity stress agent: one test failure observed during a stress run.
+type failureMessage struct {
+	BuildID       int    `json:"build_id"`
+	SHA           string `json:"sha"`
+	ServerURL     string `json:"server_url"`
+	Package       string `json:"package"`
+	TestName      string `json:"test_name"`
+	FailureLogURL string `json:"failure_log_url"`
+}
+
+// logFetcher retrieves the raw failure log a failureMessage points to.
+type logFetcher func(ctx context.Context, url string) ([]byte, error)
+
+func httpLogFetcher(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fingerprintCache is a small LRU of recently-seen (package, test,
+// fingerprint) keys, each with its own mutex. Serializing all handling of
+// messages that share a key means a thundering herd of identical failures
+// from many parallel stress agents files exactly one issue and comments on
+// it for every other occurrence, instead of racing each other through
+// Search-then-Create.
+type fingerprintCache struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List
+	elements map[string]*list.Element
+	locks    map[string]*sync.Mutex
+}
+
+func newFingerprintCache(size int) *fingerprintCache {
+	return &fingerprintCache{
+		size:     size,
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+		locks:    make(map[string]*sync.Mutex),
+	}
+}
+
+// lock acquires the per-key mutex for key, creating it if necessary, and
+// returns a function that releases it. Callers should hold it for the
+// duration of processing a message with that dedupe key.
+func (c *fingerprintCache) lock(key string) func() {
+	c.mu.Lock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[key] = l
+	}
+	if e, ok := c.elements[key]; ok {
+		c.order.MoveToFront(e)
+	} else {
+		c.elements[key] = c.order.PushFront(key)
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			oldKey := oldest.Value.(string)
+			delete(c.elements, oldKey)
+			delete(c.locks, oldKey)
+		}
+	}
+	c.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// readShardNum returns this process's shard number, read from the file
+// named by shardNumFileEnv, or 0 if that env var isn't set. It's used for
+// logging only -- see the shardNumFileEnv doc comment for what it does and
+// doesn't coordinate.
+func readShardNum() (int, error) {
+	path, ok := os.LookupEnv(shardNumFileEnv)
+	if !ok {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// runPubSub subscribes to subURL and, for each failureMessage it receives,
+// fetches the referenced log and feeds it through postFailure. A message is
+// acked only once its failure has been successfully posted (or confirmed to
+// already have a comment added), giving at-least-once semantics: if the
+// process crashes between receipt and ack, the message is simply
+// redelivered and handled again.
+func runPubSub(
+	ctx context.Context, subURL string, tracker IssueTracker, fetch logFetcher, limiter *rate.Limiter,
+) error {
+	sub, err := pubsub.OpenSubscription(ctx, subURL)
+	if err != nil {
+		return err
+	}
+	defer sub.Shutdown(ctx)
+
+	log.Printf("github-post: subscribing to %s", subURL)
+
+	return runPubSubSubscription(ctx, sub, tracker, fetch, limiter)
+}
+
+// runPubSubSubscription drives an already-open subscription; it's split out
+// from runPubSub so tests can open a subscription themselves (and so be
+// guaranteed to observe every message a test publishes) before handing it
+// off to the same receive/dispatch/ack loop production code runs.
+func runPubSubSubscription(
+	ctx context.Context,
+	sub *pubsub.Subscription,
+	tracker IssueTracker,
+	fetch logFetcher,
+	limiter *rate.Limiter,
+) error {
+	shard, err := readShardNum()
+	if err != nil {
+		return err
+	}
+	log.Printf("github-post: shard %d receiving", shard)
+
+	cache := newFingerprintCache(defaultDedupeCacheSize)
+
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go func(msg *pubsub.Message) {
+			if err := handleMessage(ctx, msg, tracker, fetch, cache, limiter); err != nil {
+				log.Printf("github-post: %s", err)
+				msg.Nack()
+				return
+			}
+			msg.Ack()
+		}(msg)
+	}
+}
+
+func handleMessage(
+	ctx context.Context,
+	msg *pubsub.Message,
+	tracker IssueTracker,
+	fetch logFetcher,
+	cache *fingerprintCache,
+	limiter *rate.Limiter,
+) error {
+	var fm failureMessage
+	if err := json.Unmarshal(msg.Body, &fm); err != nil {
+		return err
+	}
+
+	data, err := fetch(ctx, fm.FailureLogURL)
+	if err != nil {
+		return err
+	}
+
+	fp := fingerprintFailure(string(data))
+	// Serialize every message sharing this (package, test, fingerprint)
+	// key through the rest of this function. That turns a thundering herd
+	// of identical failures reported by many parallel stress agents into
+	// one Create followed by a comment for every other occurrence, rather
+	// than racing each other through Search-then-Create.
+	unlock := cache.lock(fmt.Sprintf("%s|%s|%s", fm.Package, fm.TestName, fp))
+	defer unlock()
+
+	if limiter != nil {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	params := failureParams{
+		sha:       fm.SHA,
+		serverURL: fm.ServerURL,
+		buildID:   fm.BuildID,
+		// Pub/sub messages don't carry TeamCity's tags/goflags build
+		// parameters, so the parameters block is empty for this mode.
+		packageName: strings.TrimPrefix(fm.Package, cockroachPkgPrefix),
+		parameters:  "```\n```",
+	}
+	return postFailure(ctx, tracker, params, fm.TestName, data)
+}
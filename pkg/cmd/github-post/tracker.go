@@ -0,0 +1,66 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Issue is a backend-agnostic view of an issue found or filed by an
+// IssueTracker.
+type Issue struct {
+	Number int
+}
+
+// IssueRequest describes a new issue (or, reused for comments, just its
+// Body) to post to an IssueTracker.
+type IssueRequest struct {
+	Title  string
+	Body   string
+	Labels []string
+}
+
+// IssueTracker abstracts over the forge that stress-failure reports are
+// filed against, so the formatting/redaction/dedup pipeline in runGH can
+// run unmodified regardless of whether a fork hosts its issue tracker on
+// GitHub, Gitea, Forgejo, or something else entirely.
+type IssueTracker interface {
+	// Search reports whether an open issue titled title already exists
+	// and, if so, its Issue.
+	Search(ctx context.Context, owner, repo, title string) (found bool, issue Issue, err error)
+	// Create files a new issue.
+	Create(ctx context.Context, owner, repo string, req IssueRequest) error
+	// Comment adds a comment to the issue numbered number.
+	Comment(ctx context.Context, owner, repo string, number int, body string) error
+	// Label applies labels to the issue numbered number.
+	Label(ctx context.Context, owner, repo string, number int, labels []string) error
+}
+
+// newIssueTracker constructs the IssueTracker named by backend (one of
+// "", "github", or "gitea"; "" defaults to "github"), reading whatever
+// credentials that backend requires from the environment.
+func newIssueTracker(backend string) (IssueTracker, error) {
+	switch backend {
+	case "", "github":
+		return newGithubTrackerFromEnv()
+	case "gitea":
+		return newGiteaTrackerFromEnv()
+	case "gitlab":
+		return nil, fmt.Errorf("%s=gitlab is not yet implemented", issueTrackerEnv)
+	default:
+		return nil, fmt.Errorf("unknown %s %q", issueTrackerEnv, backend)
+	}
+}